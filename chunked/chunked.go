@@ -0,0 +1,233 @@
+// Copyright (C) 2023 Sneller, Inc.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package chunked lays iguana-compressed data out the way estargz and
+// zstd-chunked lay out container image layers: fixed-logical-size
+// chunks named by the SHA-256 digest of their compressed bytes, plus a
+// JSON manifest describing where each chunk falls in the uncompressed
+// stream. Unlike the seekable package's self-contained container, the
+// chunks and the manifest here are meant to live apart - e.g. as
+// separate blobs in a content-addressed store fetched individually by
+// a CDN or registry.
+package chunked
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/SnellerInc/sneller/ion/zion/iguana"
+
+	"github.com/SnellerInc/compbench/internal/framecache"
+)
+
+// DefaultChunkSize is the logical (uncompressed) size of each chunk
+// when Writer is constructed with a chunkSize of zero.
+const DefaultChunkSize = 1024 * 1024
+
+// DefaultThreshold is the entropy coding threshold used when Writer is
+// constructed with a threshold of zero.
+const DefaultThreshold = 1.0
+
+// DefaultCacheChunks is the number of decoded chunks a Reader keeps in
+// its LRU cache.
+const DefaultCacheChunks = 8
+
+// ManifestEntry describes one chunk's placement in the uncompressed
+// stream and the digest used to fetch its compressed bytes.
+type ManifestEntry struct {
+	Digest             string `json:"digest"`
+	UncompressedOffset int64  `json:"uncompressed_offset"`
+	UncompressedSize   int64  `json:"uncompressed_size"`
+	CompressedSize     int64  `json:"compressed_size"`
+}
+
+// Manifest lists every chunk produced for a stream, in order.
+type Manifest struct {
+	ChunkSize int64           `json:"chunk_size"`
+	Chunks    []ManifestEntry `json:"chunks"`
+}
+
+// Writer splits an input stream into fixed-logical-size chunks,
+// compresses each independently, and hands the compressed bytes to put
+// keyed by their SHA-256 digest.
+type Writer struct {
+	chunkSize int
+	threshold float64
+	put       func(digest string, compressed []byte) error
+
+	enc      iguana.Encoder
+	manifest Manifest
+	uoffset  int64
+}
+
+// NewWriter returns a Writer that compresses chunkSize-byte chunks (or
+// DefaultChunkSize if zero) at the given entropy coding threshold (or
+// DefaultThreshold if zero), storing each one via put.
+func NewWriter(chunkSize int, threshold float64, put func(digest string, compressed []byte) error) *Writer {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	if threshold == 0 {
+		threshold = DefaultThreshold
+	}
+	return &Writer{
+		chunkSize: chunkSize,
+		threshold: threshold,
+		put:       put,
+		manifest:  Manifest{ChunkSize: int64(chunkSize)},
+	}
+}
+
+// WriteFrom reads r to completion, emitting one chunk per ChunkSize
+// bytes (the final chunk may be shorter), and returns the manifest
+// describing the chunks it produced.
+func (w *Writer) WriteFrom(r io.Reader) (*Manifest, error) {
+	buf := make([]byte, w.chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if err := w.writeChunk(buf[:n]); err != nil {
+				return nil, err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &w.manifest, nil
+}
+
+func (w *Writer) writeChunk(mem []byte) error {
+	out, err := w.enc.Compress(mem, nil, float32(w.threshold))
+	if err != nil {
+		return err
+	}
+	digest := digestOf(out)
+	if err := w.put(digest, out); err != nil {
+		return err
+	}
+	w.manifest.Chunks = append(w.manifest.Chunks, ManifestEntry{
+		Digest:             digest,
+		UncompressedOffset: w.uoffset,
+		UncompressedSize:   int64(len(mem)),
+		CompressedSize:     int64(len(out)),
+	})
+	w.uoffset += int64(len(mem))
+	return nil
+}
+
+func digestOf(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Reader implements io.ReaderAt over a chunked stream, fetching and
+// decoding only the chunks needed to satisfy a given read.
+type Reader struct {
+	manifest Manifest
+	fetch    func(digest string) (io.ReadCloser, error)
+
+	mu    sync.Mutex
+	dec   iguana.Decoder
+	cache *framecache.Cache
+}
+
+// Open parses a JSON manifest (as written by Writer) and returns a
+// Reader that resolves chunk digests via fetch on demand.
+func Open(manifest io.Reader, fetch func(digest string) (io.ReadCloser, error)) (io.ReaderAt, error) {
+	var m Manifest
+	if err := json.NewDecoder(manifest).Decode(&m); err != nil {
+		return nil, fmt.Errorf("chunked: decoding manifest: %w", err)
+	}
+	return &Reader{
+		manifest: m,
+		fetch:    fetch,
+		cache:    framecache.New(DefaultCacheChunks),
+	}, nil
+}
+
+// ReadAt implements io.ReaderAt over the uncompressed byte stream.
+func (r *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("chunked: negative offset %d", off)
+	}
+	chunks := r.manifest.Chunks
+	i := sort.Search(len(chunks), func(i int) bool {
+		c := &chunks[i]
+		return c.UncompressedOffset+c.UncompressedSize > off
+	})
+	n := 0
+	for n < len(p) && i < len(chunks) {
+		c := &chunks[i]
+		data, err := r.chunk(i, c)
+		if err != nil {
+			return n, err
+		}
+		skip := off - c.UncompressedOffset
+		if skip > int64(len(data)) {
+			skip = int64(len(data))
+		}
+		cn := copy(p[n:], data[skip:])
+		n += cn
+		off += int64(cn)
+		i++
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// chunk fetches and decodes chunk i, consulting and populating r.cache
+// (itself safe for concurrent use, as ReadAt's contract requires). r.mu
+// only serializes the fetch-and-decode sequence on a cache miss so
+// concurrent misses for the same chunk don't do redundant work.
+func (r *Reader) chunk(i int, c *ManifestEntry) ([]byte, error) {
+	if data, ok := r.cache.Get(i); ok {
+		return data, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if data, ok := r.cache.Get(i); ok {
+		return data, nil
+	}
+
+	rc, err := r.fetch(c.Digest)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	compressed, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	if got := digestOf(compressed); got != c.Digest {
+		return nil, fmt.Errorf("chunked: digest mismatch for chunk %d: got %s, want %s", i, got, c.Digest)
+	}
+	out, err := r.dec.DecompressTo(make([]byte, 0, c.UncompressedSize), compressed)
+	if err != nil {
+		return nil, err
+	}
+	r.cache.Add(i, out)
+	return out, nil
+}
@@ -0,0 +1,157 @@
+// Copyright (C) 2023 Sneller, Inc.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package chunked
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+)
+
+// testStore is an in-memory blob store keyed by digest, standing in for
+// the CDN/content-addressed store Open's fetch callback would normally
+// hit.
+type testStore map[string][]byte
+
+func (s testStore) put(digest string, compressed []byte) error {
+	s[digest] = append([]byte(nil), compressed...)
+	return nil
+}
+
+func (s testStore) fetch(digest string) (io.ReadCloser, error) {
+	b, ok := s[digest]
+	if !ok {
+		return nil, errNotFound
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+var errNotFound = errors.New("chunked_test: digest not found")
+
+func testInput(n int) []byte {
+	buf := make([]byte, n)
+	for i := range buf {
+		buf[i] = byte((i*7 + i/251) % 251)
+	}
+	return buf
+}
+
+func writeChunked(t *testing.T, chunkSize int, in []byte) (testStore, *Manifest) {
+	t.Helper()
+	store := testStore{}
+	wr := NewWriter(chunkSize, 1.0, store.put)
+	manifest, err := wr.WriteFrom(bytes.NewReader(in))
+	if err != nil {
+		t.Fatalf("WriteFrom: %s", err)
+	}
+	return store, manifest
+}
+
+func openReader(t *testing.T, store testStore, manifest *Manifest) io.ReaderAt {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(manifest); err != nil {
+		t.Fatalf("encoding manifest: %s", err)
+	}
+	r, err := Open(&buf, store.fetch)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	return r
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := testInput(10 * 1024)
+	store, manifest := writeChunked(t, 4096, in)
+	r := openReader(t, store, manifest)
+
+	out := make([]byte, len(in))
+	if _, err := r.ReadAt(out, 0); err != nil {
+		t.Fatalf("ReadAt: %s", err)
+	}
+	if !bytes.Equal(out, in) {
+		t.Fatalf("round trip mismatch")
+	}
+}
+
+// TestReadAtSpansChunks exercises a read that starts mid-chunk and
+// crosses into the next one, to catch off-by-one errors in the
+// chunk-boundary bookkeeping in ReadAt.
+func TestReadAtSpansChunks(t *testing.T) {
+	in := testInput(10 * 1024)
+	store, manifest := writeChunked(t, 4096, in)
+	r := openReader(t, store, manifest)
+
+	const start = 4000
+	out := make([]byte, 200)
+	n, err := r.ReadAt(out, start)
+	if err != nil {
+		t.Fatalf("ReadAt: %s", err)
+	}
+	if n != len(out) {
+		t.Fatalf("got %d bytes, want %d", n, len(out))
+	}
+	if !bytes.Equal(out, in[start:start+len(out)]) {
+		t.Fatalf("spanning read mismatch")
+	}
+}
+
+func TestReadAtNegativeOffset(t *testing.T) {
+	in := testInput(1024)
+	store, manifest := writeChunked(t, 512, in)
+	r := openReader(t, store, manifest)
+
+	if _, err := r.ReadAt(make([]byte, 8), -1); err == nil {
+		t.Fatal("ReadAt with negative offset: got nil error, want one")
+	}
+}
+
+func TestReadAtEOF(t *testing.T) {
+	in := testInput(1024)
+	store, manifest := writeChunked(t, 512, in)
+	r := openReader(t, store, manifest)
+
+	out := make([]byte, 16)
+	_, err := r.ReadAt(out, int64(len(in)-8))
+	if err != io.EOF {
+		t.Fatalf("got error %v, want io.EOF", err)
+	}
+}
+
+func TestDigestMismatch(t *testing.T) {
+	in := testInput(1024)
+	store, manifest := writeChunked(t, 512, in)
+	for digest := range store {
+		store[digest][0] ^= 0xff
+	}
+	r := openReader(t, store, manifest)
+
+	if _, err := r.ReadAt(make([]byte, 8), 0); err == nil {
+		t.Fatal("ReadAt over a corrupted chunk: got nil error, want one")
+	}
+}
+
+func TestFetchError(t *testing.T) {
+	in := testInput(1024)
+	store, manifest := writeChunked(t, 512, in)
+	delete(store, manifest.Chunks[0].Digest)
+	r := openReader(t, store, manifest)
+
+	if _, err := r.ReadAt(make([]byte, 8), 0); err == nil {
+		t.Fatal("ReadAt with a missing chunk: got nil error, want one")
+	}
+}
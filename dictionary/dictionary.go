@@ -0,0 +1,228 @@
+// Copyright (C) 2023 Sneller, Inc.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package dictionary trains zstd-style shared dictionaries for small,
+// similar payloads (log lines, JSON records).
+//
+// TrainDictionary is the only thing this package can offer today: the
+// vendored github.com/SnellerInc/sneller/ion/zion/iguana package does
+// not expose Encoder.SetDictionary/Decoder.SetDictionary hooks to
+// preload the match-finder's window without shipping the dictionary's
+// own bytes in the compressed output, and there is no reliable way to
+// fake that with the existing Compress/DecompressTo API: prepending the
+// dictionary to every window and re-entropy-coding the concatenation
+// re-transmits the whole dictionary on every call, which makes the
+// ratio worse than not using a dictionary at all for the small-payload
+// case this package exists for. A real priming mode has to wait on
+// native dictionary support in iguana; this package does not attempt a
+// workaround that doesn't work.
+package dictionary
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"sort"
+)
+
+// minMatch is the shortest substring considered as a dictionary
+// candidate, matching iguana's own minimum match length.
+const minMatch = 4
+
+// headerSize is the size of the small dictID header prepended to the
+// trained dictionary content.
+const headerSize = 8
+
+var dictMagic = [4]byte{'I', 'G', 'D', '1'}
+
+// ErrTooShort is returned by TrainDictionary when the training samples
+// contain no repeated substrings long enough to build a dictionary.
+var ErrTooShort = errors.New("dictionary: training samples too short or not repetitive enough")
+
+// TrainDictionary builds a dictionary of at most dictSize bytes from
+// samples. It scores candidate substrings (found via a suffix array and
+// LCP array over the concatenated samples) by frequency*(length -
+// overhead) and greedily packs the highest-scoring, non-overlapping
+// substrings into the dictionary, with the hottest bytes placed last so
+// they sit closest to the data they prime.
+func TrainDictionary(samples [][]byte, dictSize int) ([]byte, error) {
+	if dictSize <= 0 {
+		return nil, errors.New("dictionary: dictSize must be positive")
+	}
+	var corpus []byte
+	for _, s := range samples {
+		corpus = append(corpus, s...)
+	}
+	if len(corpus) < minMatch {
+		return nil, ErrTooShort
+	}
+
+	sa := suffixArray(corpus)
+	lcp := lcpArray(corpus, sa)
+
+	type candidate struct {
+		start, length, freq int
+	}
+	var candidates []candidate
+	i := 1
+	for i < len(lcp) {
+		if lcp[i] < minMatch {
+			i++
+			continue
+		}
+		l := lcp[i]
+		j := i
+		for j < len(lcp) && lcp[j] >= l {
+			j++
+		}
+		candidates = append(candidates, candidate{start: sa[i], length: l, freq: j - i + 1})
+		i = j
+	}
+	if len(candidates) == 0 {
+		return nil, ErrTooShort
+	}
+
+	const overhead = 4
+	sort.Slice(candidates, func(a, b int) bool {
+		ca, cb := candidates[a], candidates[b]
+		return ca.freq*(ca.length-overhead) > cb.freq*(cb.length-overhead)
+	})
+
+	type span struct{ start, end int }
+	var picked []span
+	total := 0
+	overlaps := func(s span) bool {
+		for _, p := range picked {
+			if s.start < p.end && p.start < s.end {
+				return true
+			}
+		}
+		return false
+	}
+	for _, c := range candidates {
+		if total >= dictSize {
+			break
+		}
+		s := span{c.start, c.start + c.length}
+		if overlaps(s) {
+			continue
+		}
+		picked = append(picked, s)
+		total += c.length
+	}
+	if len(picked) == 0 {
+		return nil, ErrTooShort
+	}
+
+	var content []byte
+	for i := len(picked) - 1; i >= 0; i-- {
+		s := picked[i]
+		content = append(content, corpus[s.start:s.end]...)
+	}
+	if len(content) > dictSize {
+		content = content[len(content)-dictSize:]
+	}
+
+	out := make([]byte, headerSize, headerSize+len(content))
+	copy(out[0:4], dictMagic[:])
+	h := fnv.New32a()
+	h.Write(content)
+	binary.LittleEndian.PutUint32(out[4:8], h.Sum32())
+	out = append(out, content...)
+	return out, nil
+}
+
+// ID returns the dictID recorded in dict's header.
+func ID(dict []byte) (uint32, error) {
+	if len(dict) < headerSize || string(dict[0:4]) != string(dictMagic[:]) {
+		return 0, errors.New("dictionary: bad dictionary header")
+	}
+	return binary.LittleEndian.Uint32(dict[4:8]), nil
+}
+
+// Content returns the trained dictionary bytes recorded in dict (as
+// produced by TrainDictionary), without the dictID header.
+func Content(dict []byte) ([]byte, error) {
+	if len(dict) < headerSize || string(dict[0:4]) != string(dictMagic[:]) {
+		return nil, errors.New("dictionary: bad dictionary header")
+	}
+	return dict[headerSize:], nil
+}
+
+// suffixArray builds the suffix array of s by prefix doubling: rank
+// suffixes by their first 2^k bytes, then refine by comparing pairs of
+// those ranks to get the order for 2^(k+1) bytes, for O(n log^2 n)
+// total time without the per-comparison string allocation a naive
+// sort.Slice over s[i:] would do.
+func suffixArray(s []byte) []int {
+	n := len(s)
+	sa := make([]int, n)
+	rank := make([]int, n)
+	tmp := make([]int, n)
+	for i := 0; i < n; i++ {
+		sa[i] = i
+		rank[i] = int(s[i])
+	}
+	key := func(i, k int) (int, int) {
+		r2 := -1
+		if i+k < n {
+			r2 = rank[i+k]
+		}
+		return rank[i], r2
+	}
+	for k := 1; k < n; k *= 2 {
+		sort.Slice(sa, func(a, b int) bool {
+			a1, a2 := key(sa[a], k)
+			b1, b2 := key(sa[b], k)
+			if a1 != b1 {
+				return a1 < b1
+			}
+			return a2 < b2
+		})
+		tmp[sa[0]] = 0
+		for i := 1; i < n; i++ {
+			tmp[sa[i]] = tmp[sa[i-1]]
+			a1, a2 := key(sa[i-1], k)
+			b1, b2 := key(sa[i], k)
+			if a1 != b1 || a2 != b2 {
+				tmp[sa[i]]++
+			}
+		}
+		copy(rank, tmp)
+		if rank[sa[n-1]] == n-1 {
+			break
+		}
+	}
+	return sa
+}
+
+func lcpArray(s []byte, sa []int) []int {
+	lcp := make([]int, len(sa))
+	for i := 1; i < len(sa); i++ {
+		lcp[i] = commonPrefixLen(s[sa[i-1]:], s[sa[i]:])
+	}
+	return lcp
+}
+
+func commonPrefixLen(a, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	i := 0
+	for i < n && a[i] == b[i] {
+		i++
+	}
+	return i
+}
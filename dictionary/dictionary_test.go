@@ -0,0 +1,145 @@
+// Copyright (C) 2023 Sneller, Inc.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package dictionary
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+)
+
+func TestTrainDictionaryRoundTrip(t *testing.T) {
+	samples := [][]byte{
+		[]byte(`{"level":"info","msg":"request handled"}`),
+		[]byte(`{"level":"info","msg":"request failed"}`),
+		[]byte(`{"level":"warn","msg":"request handled"}`),
+	}
+	dict, err := TrainDictionary(samples, 64)
+	if err != nil {
+		t.Fatalf("TrainDictionary: %s", err)
+	}
+
+	id, err := ID(dict)
+	if err != nil {
+		t.Fatalf("ID: %s", err)
+	}
+	id2, err := ID(dict)
+	if err != nil || id2 != id {
+		t.Fatalf("ID not stable across calls: %d vs %d (err %v)", id, id2, err)
+	}
+
+	content, err := Content(dict)
+	if err != nil {
+		t.Fatalf("Content: %s", err)
+	}
+	if len(content) == 0 {
+		t.Fatal("Content returned no bytes")
+	}
+	if len(content) > 64 {
+		t.Fatalf("Content longer than requested dictSize: %d", len(content))
+	}
+}
+
+// TestTrainDictionaryPicksRepeatedSubstring uses a small, fixed corpus
+// with exactly one substring (longer than minMatch) that repeats, so
+// the expected dictionary content is unambiguous: it must contain
+// "PATTERNS" (its shorter overlapping runs are skipped), not some
+// unrelated slice of the input.
+func TestTrainDictionaryPicksRepeatedSubstring(t *testing.T) {
+	samples := [][]byte{
+		[]byte("aaaPATTERNSaaa"),
+		[]byte("bbbPATTERNSbbb"),
+	}
+	dict, err := TrainDictionary(samples, 64)
+	if err != nil {
+		t.Fatalf("TrainDictionary: %s", err)
+	}
+	content, err := Content(dict)
+	if err != nil {
+		t.Fatalf("Content: %s", err)
+	}
+	if !bytes.Contains(content, []byte("PATTERNS")) {
+		t.Fatalf("Content %q does not contain the only repeated substring in the input", content)
+	}
+}
+
+func TestTrainDictionaryTooShort(t *testing.T) {
+	_, err := TrainDictionary([][]byte{[]byte("ab")}, 64)
+	if err != ErrTooShort {
+		t.Fatalf("got error %v, want ErrTooShort", err)
+	}
+}
+
+func TestTrainDictionaryNoRepetition(t *testing.T) {
+	// No substring of length >= minMatch repeats across these samples,
+	// so there is nothing to build a dictionary from.
+	_, err := TrainDictionary([][]byte{[]byte("abcdefgh"), []byte("ijklmnop")}, 64)
+	if err != ErrTooShort {
+		t.Fatalf("got error %v, want ErrTooShort", err)
+	}
+}
+
+func TestTrainDictionaryInvalidSize(t *testing.T) {
+	_, err := TrainDictionary([][]byte{[]byte("aaaaaaaa")}, 0)
+	if err == nil {
+		t.Fatal("TrainDictionary with dictSize 0: got nil error, want one")
+	}
+}
+
+func TestIDBadHeader(t *testing.T) {
+	if _, err := ID([]byte("short")); err == nil {
+		t.Fatal("ID on a too-short dictionary: got nil error, want one")
+	}
+	if _, err := Content([]byte("short")); err == nil {
+		t.Fatal("Content on a too-short dictionary: got nil error, want one")
+	}
+}
+
+// TestSuffixArray checks suffixArray against the textbook suffix array
+// for "banana", computed by sorting suffixes directly.
+func TestSuffixArray(t *testing.T) {
+	s := []byte("banana")
+	got := suffixArray(s)
+
+	want := make([]int, len(s))
+	for i := range want {
+		want[i] = i
+	}
+	sort.Slice(want, func(a, b int) bool {
+		return bytes.Compare(s[want[a]:], s[want[b]:]) < 0
+	})
+
+	if len(got) != len(want) {
+		t.Fatalf("suffix array length: got %d, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("suffix array mismatch at %d: got %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestLCPArray(t *testing.T) {
+	s := []byte("banana")
+	sa := suffixArray(s)
+	lcp := lcpArray(s, sa)
+
+	for i := 1; i < len(sa); i++ {
+		want := commonPrefixLen(s[sa[i-1]:], s[sa[i]:])
+		if lcp[i] != want {
+			t.Fatalf("lcp[%d]: got %d, want %d", i, lcp[i], want)
+		}
+	}
+}
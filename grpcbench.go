@@ -0,0 +1,210 @@
+// Copyright (C) 2023 Sneller, Inc.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/stats"
+
+	"github.com/SnellerInc/compbench/grpcenc"
+)
+
+// rawCodecName is registered as a grpc content-subtype so that
+// grpcBenchMain can stream plain []byte messages (message-sized chunks
+// of the input file) instead of generating protobuf types for a
+// one-off benchmark.
+const rawCodecName = "compbenchraw"
+
+func init() {
+	encoding.RegisterCodec(rawCodec{})
+}
+
+// rawMessage is the message type streamed over the loopback
+// connection: the payload bytes for a single grpc-encoding frame.
+type rawMessage struct {
+	data []byte
+}
+
+type rawCodec struct{}
+
+func (rawCodec) Name() string { return rawCodecName }
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(*rawMessage)
+	if !ok {
+		return nil, fmt.Errorf("grpcbench: unexpected message type %T", v)
+	}
+	return m.data, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(*rawMessage)
+	if !ok {
+		return fmt.Errorf("grpcbench: unexpected message type %T", v)
+	}
+	m.data = append(m.data[:0], data...)
+	return nil
+}
+
+var benchStreamDesc = grpc.StreamDesc{
+	StreamName:    "Download",
+	Handler:       downloadHandler,
+	ServerStreams: true,
+}
+
+var benchServiceDesc = grpc.ServiceDesc{
+	ServiceName: "compbench.Bench",
+	HandlerType: (*any)(nil),
+	Streams:     []grpc.StreamDesc{benchStreamDesc},
+	Metadata:    "grpcbench.go",
+}
+
+// downloadHandler streams srv.(*grpcBenchServer).data to the client in
+// iguanaWindowSize-sized messages, each one compressed on the wire
+// using the "iguana" grpc-encoding codec.
+func downloadHandler(srv any, stream grpc.ServerStream) error {
+	data := srv.(*grpcBenchServer).data
+	for len(data) > 0 {
+		n := iguanaWindowSize
+		if n > len(data) {
+			n = len(data)
+		}
+		if err := stream.SendMsg(&rawMessage{data: data[:n]}); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+type grpcBenchServer struct {
+	data []byte
+	// wireBytes accumulates the compressed size of every message sent,
+	// as reported by the grpc stats handler below.
+	wireBytes int64
+}
+
+type wireStats struct {
+	total *int64
+}
+
+func (wireStats) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context { return ctx }
+func (w wireStats) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	if out, ok := s.(*stats.OutPayload); ok {
+		atomic.AddInt64(w.total, int64(out.WireLength))
+	}
+}
+func (wireStats) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context { return ctx }
+func (wireStats) HandleConn(context.Context, stats.ConnStats)                       {}
+
+// grpcBenchMain implements the "grpcbench" subcommand: it serves the
+// named file over a loopback grpc connection compressed with the
+// "iguana" codec, then repeatedly streams it back to measure decode
+// throughput the same way benchMain measures iguanaDecompress.
+func grpcBenchMain() {
+	args := os.Args[1:]
+	if len(args) != 1 {
+		fatalf("usage: %s grpcbench <file>\n", os.Args[0])
+	}
+	buf, err := os.ReadFile(args[0])
+	if err != nil {
+		fatalf("reading file: %s", err)
+	}
+
+	benchSrv := &grpcBenchServer{data: buf}
+	srv := grpc.NewServer(
+		grpc.StatsHandler(wireStats{total: &benchSrv.wireBytes}),
+	)
+	srv.RegisterService(&benchServiceDesc, benchSrv)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		fatalf("listening: %s", err)
+	}
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(
+			grpc.UseCompressor(grpcenc.Name),
+			grpc.CallContentSubtype(rawCodecName),
+		),
+	)
+	if err != nil {
+		fatalf("dialing: %s", err)
+	}
+	defer conn.Close()
+
+	download := func() (int64, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		cs, err := conn.NewStream(ctx, &benchStreamDesc, "/compbench.Bench/Download")
+		if err != nil {
+			return 0, err
+		}
+		var n int64
+		msg := new(rawMessage)
+		for {
+			if err := cs.RecvMsg(msg); err != nil {
+				if err == io.EOF {
+					break
+				}
+				return n, err
+			}
+			n += int64(len(msg.data))
+		}
+		return n, nil
+	}
+
+	// one untimed run to populate wireBytes with the compressed size
+	// of the payload, mirroring iguanaCompress's len(comp) output.
+	if _, err := download(); err != nil {
+		fatalf("grpc download: %s", err)
+	}
+	compressed := atomic.LoadInt64(&benchSrv.wireBytes)
+
+	var min time.Duration
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		start := time.Now()
+		if _, err := download(); err != nil {
+			fatalf("grpc download: %s", err)
+		}
+		dur := time.Since(start)
+		if min == 0 || dur < min {
+			min = dur
+		}
+	}
+	multiplier := (1e12) / float64(time.Second)
+	mbps := (float64(len(buf)) / float64(min)) * multiplier
+	fmt.Printf("%d %.4g MB/s\n", compressed, mbps)
+}
+
+func benchGRPC() func(string) []string {
+	return func(filename string) []string {
+		return []string{selfexe(), "grpcbench", filename}
+	}
+}
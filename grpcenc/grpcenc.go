@@ -0,0 +1,53 @@
+// Copyright (C) 2023 Sneller, Inc.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package grpcenc registers iguana as a google.golang.org/grpc/encoding
+// message compressor under the name "iguana", so that a grpc client or
+// server can negotiate grpc-encoding: iguana exactly like the built-in
+// "gzip" codec. Importing this package for its side effect is enough to
+// make the codec available:
+//
+//	import _ "github.com/SnellerInc/compbench/grpcenc"
+//	...
+//	grpc.Dial(addr, grpc.WithDefaultCallOptions(grpc.UseCompressor(grpcenc.Name)))
+package grpcenc
+
+import (
+	"io"
+
+	"google.golang.org/grpc/encoding"
+
+	"github.com/SnellerInc/compbench/iguanaio"
+)
+
+// Name is the value negotiated in the grpc-encoding header.
+const Name = "iguana"
+
+func init() {
+	encoding.RegisterCompressor(compressor{})
+}
+
+// compressor adapts iguanaio's streaming Writer/Reader to
+// grpc/encoding.Compressor.
+type compressor struct{}
+
+func (compressor) Name() string { return Name }
+
+func (compressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return iguanaio.NewWriter(w), nil
+}
+
+func (compressor) Decompress(r io.Reader) (io.Reader, error) {
+	return iguanaio.NewReader(r)
+}
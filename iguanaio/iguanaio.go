@@ -0,0 +1,313 @@
+// Copyright (C) 2023 Sneller, Inc.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package iguanaio provides a compress/gzip-style streaming API on top of
+// the iguana entropy coder. It frames arbitrarily large inputs as a
+// sequence of independently-decodable windows (a 3-byte little-endian
+// length prefix followed by the compressed payload), exactly like the
+// ad-hoc framing used by compbench's own benchmarking harness.
+package iguanaio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/SnellerInc/sneller/ion/zion/iguana"
+)
+
+// DefaultWindowSize is the window size used when Options.WindowSize is zero.
+const DefaultWindowSize = 256 * 1024
+
+// DefaultThreshold is the entropy coding threshold used when
+// Options.Threshold is nil.
+const DefaultThreshold = 1.0
+
+// maxFrameSize is the largest compressed frame the 3-byte little-endian
+// length prefix can represent.
+const maxFrameSize = 1<<24 - 1
+
+// ErrInvalidFrame is returned by a Reader when the underlying stream
+// contains a truncated or otherwise malformed frame.
+var ErrInvalidFrame = errors.New("iguanaio: invalid frame")
+
+// Options configures a Writer.
+type Options struct {
+	// Threshold is the entropy coding threshold passed to
+	// iguana.Encoder.Compress for every frame. nil means DefaultThreshold;
+	// a pointer to 0 explicitly disables ANS entropy coding (as
+	// main.go's "iguana_avx512_noans" benchmark entry does), so the
+	// zero value of float64 can't double as "unset".
+	Threshold *float64
+	// WindowSize is the maximum number of uncompressed bytes buffered
+	// into a single frame before it is flushed. Zero means DefaultWindowSize.
+	WindowSize int
+	// Concurrency is the number of frames that may be encoded in parallel.
+	// Zero means GOMAXPROCS; 1 and negative values disable parallel
+	// encoding.
+	Concurrency int
+}
+
+func (o *Options) threshold() float64 {
+	if o.Threshold != nil {
+		return *o.Threshold
+	}
+	return DefaultThreshold
+}
+
+func (o *Options) setDefaults() {
+	if o.WindowSize <= 0 {
+		o.WindowSize = DefaultWindowSize
+	}
+	if o.Concurrency == 0 {
+		o.Concurrency = runtime.GOMAXPROCS(0)
+	} else if o.Concurrency < 1 {
+		o.Concurrency = 1
+	}
+}
+
+// pendingFrame is a frame that is being (or has been) encoded in the
+// background. Writer emits frames strictly in the order they were
+// queued, even though encoding itself may complete out of order.
+type pendingFrame struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// Writer implements io.WriteCloser, buffering writes into fixed-size
+// windows and emitting one iguana frame per window.
+type Writer struct {
+	w    io.Writer
+	opts Options
+	buf  []byte
+
+	sem     chan struct{}
+	queue   []*pendingFrame
+	werr    error
+	encPool sync.Pool
+}
+
+// NewWriter returns a Writer with default Options.
+func NewWriter(w io.Writer) *Writer {
+	wr, err := NewWriterOptions(w, Options{})
+	if err != nil {
+		// NewWriterOptions only fails on invalid options, and the
+		// zero value is always valid.
+		panic(err)
+	}
+	return wr
+}
+
+// NewWriterOptions returns a Writer configured with opts.
+func NewWriterOptions(w io.Writer, opts Options) (*Writer, error) {
+	opts.setDefaults()
+	return &Writer{
+		w:    w,
+		opts: opts,
+		sem:  make(chan struct{}, opts.Concurrency),
+		encPool: sync.Pool{
+			New: func() any { return new(iguana.Encoder) },
+		},
+	}, nil
+}
+
+// Write implements io.Writer. It buffers p and emits a frame every time
+// the buffer reaches Options.WindowSize.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.werr != nil {
+		return 0, w.werr
+	}
+	n := len(p)
+	for len(p) > 0 {
+		room := w.opts.WindowSize - len(w.buf)
+		chunk := p
+		if len(chunk) > room {
+			chunk = chunk[:room]
+		}
+		w.buf = append(w.buf, chunk...)
+		p = p[len(chunk):]
+		if len(w.buf) >= w.opts.WindowSize {
+			if err := w.emit(w.buf); err != nil {
+				w.werr = err
+				return n - len(p), err
+			}
+			w.buf = w.buf[:0]
+		}
+	}
+	return n, nil
+}
+
+// Flush emits the current partial window as a frame (if non-empty) and
+// blocks until every frame queued so far has been written out.
+func (w *Writer) Flush() error {
+	if w.werr != nil {
+		return w.werr
+	}
+	if len(w.buf) > 0 {
+		if err := w.emit(w.buf); err != nil {
+			w.werr = err
+			return err
+		}
+		w.buf = w.buf[:0]
+	}
+	if err := w.drain(); err != nil {
+		w.werr = err
+		return err
+	}
+	return nil
+}
+
+// Close flushes any buffered data and releases resources. It does not
+// close the underlying io.Writer.
+func (w *Writer) Close() error {
+	return w.Flush()
+}
+
+// emit queues buf for (possibly parallel) encoding and opportunistically
+// drains any frames that have already finished, to avoid holding
+// arbitrarily many encoded frames in memory.
+func (w *Writer) emit(buf []byte) error {
+	cp := append([]byte(nil), buf...)
+	pf := &pendingFrame{done: make(chan struct{})}
+	w.queue = append(w.queue, pf)
+
+	w.sem <- struct{}{}
+	go func() {
+		defer func() { <-w.sem; close(pf.done) }()
+		enc := w.encPool.Get().(*iguana.Encoder)
+		defer w.encPool.Put(enc)
+		pf.data, pf.err = encodeFrame(enc, cp, w.opts.threshold())
+	}()
+
+	return w.drainReady()
+}
+
+// drainReady writes out whatever prefix of the queue has already
+// finished encoding, without blocking.
+func (w *Writer) drainReady() error {
+	for len(w.queue) > 0 {
+		pf := w.queue[0]
+		select {
+		case <-pf.done:
+		default:
+			return nil
+		}
+		if pf.err != nil {
+			return pf.err
+		}
+		if _, err := w.w.Write(pf.data); err != nil {
+			return err
+		}
+		w.queue = w.queue[1:]
+	}
+	return nil
+}
+
+// drain blocks until every queued frame has been encoded and written,
+// preserving frame order.
+func (w *Writer) drain() error {
+	for len(w.queue) > 0 {
+		pf := w.queue[0]
+		<-pf.done
+		if pf.err != nil {
+			return pf.err
+		}
+		if _, err := w.w.Write(pf.data); err != nil {
+			return err
+		}
+		w.queue = w.queue[1:]
+	}
+	return nil
+}
+
+func encodeFrame(enc *iguana.Encoder, mem []byte, threshold float64) ([]byte, error) {
+	out := make([]byte, 3, 3+len(mem))
+	var err error
+	out, err = enc.Compress(mem, out, float32(threshold))
+	if err != nil {
+		return nil, err
+	}
+	winsize := len(out) - 3
+	if winsize > maxFrameSize {
+		return nil, fmt.Errorf("iguanaio: compressed frame size %d exceeds %d-byte frame limit", winsize, maxFrameSize)
+	}
+	out[0] = byte(winsize)
+	out[1] = byte(winsize >> 8)
+	out[2] = byte(winsize >> 16)
+	return out, nil
+}
+
+// Reader implements io.ReadCloser, lazily pulling and decoding frames
+// from an underlying io.Reader. The iguana.Decoder is reused across
+// frames.
+type Reader struct {
+	r   io.Reader
+	dec iguana.Decoder
+	buf []byte
+	hdr [3]byte
+	err error
+}
+
+// NewReader returns a Reader that decodes frames written by a Writer.
+func NewReader(r io.Reader) (*Reader, error) {
+	return &Reader{r: r}, nil
+}
+
+// Read implements io.Reader.
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if err := r.nextFrame(); err != nil {
+			r.err = err
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *Reader) nextFrame() error {
+	_, err := io.ReadFull(r.r, r.hdr[:])
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return ErrInvalidFrame
+		}
+		return err
+	}
+	winsize := int(r.hdr[0]) | int(r.hdr[1])<<8 | int(r.hdr[2])<<16
+	frame := make([]byte, winsize)
+	if _, err := io.ReadFull(r.r, frame); err != nil {
+		return ErrInvalidFrame
+	}
+	out, err := r.dec.DecompressTo(r.buf[:0], frame)
+	if err != nil {
+		return err
+	}
+	r.buf = out
+	return nil
+}
+
+// Close closes the underlying reader if it implements io.Closer.
+func (r *Reader) Close() error {
+	if rc, ok := r.r.(io.Closer); ok {
+		return rc.Close()
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+// Copyright (C) 2023 Sneller, Inc.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package iguanaio
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func testInput(windows int) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < windows; i++ {
+		for j := 0; j < DefaultWindowSize/2; j++ {
+			buf.WriteByte(byte((i*7 + j) % 251))
+		}
+	}
+	return buf.Bytes()
+}
+
+func roundTrip(t *testing.T, opts Options) {
+	t.Helper()
+	in := testInput(5)
+
+	var compressed bytes.Buffer
+	wr, err := NewWriterOptions(&compressed, opts)
+	if err != nil {
+		t.Fatalf("NewWriterOptions: %s", err)
+	}
+	if _, err := wr.Write(in); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+
+	rd, err := NewReader(bytes.NewReader(compressed.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	out, err := io.ReadAll(rd)
+	if err != nil {
+		t.Fatalf("ReadAll: %s", err)
+	}
+	if !bytes.Equal(out, in) {
+		t.Fatalf("round trip mismatch: got %d bytes, want %d bytes", len(out), len(in))
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	roundTrip(t, Options{})
+}
+
+func TestRoundTripSmallWindow(t *testing.T) {
+	roundTrip(t, Options{WindowSize: 4096})
+}
+
+// TestRoundTripConcurrent exercises the parallel-encode path and checks
+// that frames are still emitted (and therefore decoded) in the order
+// they were written, not the order their encoding goroutines finished.
+func TestRoundTripConcurrent(t *testing.T) {
+	roundTrip(t, Options{WindowSize: 4096, Concurrency: 8})
+}
+
+func TestZeroThresholdIsExplicit(t *testing.T) {
+	zero := 0.0
+	opts := Options{Threshold: &zero}
+	if got := opts.threshold(); got != 0 {
+		t.Fatalf("threshold() with an explicit zero: got %g, want 0", got)
+	}
+	roundTrip(t, opts)
+}
+
+func TestReaderInvalidFrame(t *testing.T) {
+	// A 3-byte length prefix claiming more payload than is actually
+	// present must surface ErrInvalidFrame, not panic or hang.
+	truncated := []byte{0xff, 0xff, 0x00, 1, 2, 3}
+	rd, err := NewReader(bytes.NewReader(truncated))
+	if err != nil {
+		t.Fatalf("NewReader: %s", err)
+	}
+	_, err = io.ReadAll(rd)
+	if err != ErrInvalidFrame {
+		t.Fatalf("got error %v, want ErrInvalidFrame", err)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (C) 2023 Sneller, Inc.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package framecache implements a small, fixed-size, thread-safe LRU
+// cache of decoded frames/chunks, keyed by their index in a
+// table-of-contents or manifest. It backs both the seekable and
+// chunked packages' io.ReaderAt implementations, which per the
+// io.ReaderAt contract must tolerate concurrent calls.
+package framecache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Default is the capacity used when New is called with cap <= 0.
+const Default = 8
+
+// Cache is safe for concurrent use by multiple goroutines.
+type Cache struct {
+	mu  sync.Mutex
+	cap int
+	ll  *list.List
+	idx map[int]*list.Element
+}
+
+type entry struct {
+	index int
+	data  []byte
+}
+
+// New returns a Cache holding at most cap decoded frames.
+func New(cap int) *Cache {
+	if cap <= 0 {
+		cap = Default
+	}
+	return &Cache{cap: cap, ll: list.New(), idx: make(map[int]*list.Element)}
+}
+
+// Get returns the cached data for index, if present, and marks it most
+// recently used.
+func (c *Cache) Get(index int) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.idx[index]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*entry).data, true
+}
+
+// Add inserts or updates the cached data for index, evicting the least
+// recently used entry if the cache is over capacity.
+func (c *Cache) Add(index int, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.idx[index]; ok {
+		c.ll.MoveToFront(el)
+		el.Value.(*entry).data = data
+		return
+	}
+	el := c.ll.PushFront(&entry{index: index, data: data})
+	c.idx[index] = el
+	for c.ll.Len() > c.cap {
+		oldest := c.ll.Back()
+		if oldest == nil {
+			break
+		}
+		c.ll.Remove(oldest)
+		delete(c.idx, oldest.Value.(*entry).index)
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright (C) 2023 Sneller, Inc.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// benchLibCmd builds the "libbench" cmdline for the named in-process
+// pure-Go library at the given level, the same way benchSelf builds
+// the "igbench" cmdline for the AVX-512 iguana path.
+func benchLibCmd(lib, level string) func(string) []string {
+	return func(filename string) []string {
+		return []string{selfexe(), "libbench", lib, level, filename}
+	}
+}
+
+// libBenchMain implements the "libbench" subcommand: <lib> <level> <file>.
+func libBenchMain() {
+	args := os.Args[1:]
+	if len(args) != 3 {
+		fatalf("usage: %s libbench <lib> <level> <file>\n", os.Args[0])
+	}
+	lib, level, file := args[0], args[1], args[2]
+
+	var compress func([]byte) []byte
+	var decompress func(dst, src []byte) []byte
+	switch lib {
+	case "zstd":
+		n, err := strconv.Atoi(level)
+		if err != nil {
+			fatalf("bad zstd level %q: %s", level, err)
+		}
+		compress = zstdCompress(zstd.EncoderLevelFromZstd(n))
+		decompress = zstdDecompress()
+	case "lz4":
+		n, err := strconv.Atoi(level)
+		if err != nil {
+			fatalf("bad lz4 level %q: %s", level, err)
+		}
+		compress = lz4Compress(lz4Level(n))
+		decompress = lz4Decompress
+	case "snappy":
+		compress = snappyCompress
+		decompress = snappyDecompress
+	case "brotli":
+		n, err := strconv.Atoi(level)
+		if err != nil {
+			fatalf("bad brotli level %q: %s", level, err)
+		}
+		compress = brotliCompress(n)
+		decompress = brotliDecompress
+	default:
+		fatalf("unknown library %q", lib)
+	}
+
+	// flag.Args() is what benchLib consults for the input filename,
+	// mirroring benchMain's own flag.Parse()/flag.Args() usage.
+	os.Args = []string{os.Args[0], file}
+	flag.Parse()
+	benchLib(lib, compress, decompress)
+}
+
+// benchLib runs the same 3-second min-latency decompression loop as
+// benchMain, against an arbitrary pure-Go compress/decompress pair, and
+// prints the "%d %.4g MB/s" line that selfParse consumes.
+func benchLib(name string, compress func([]byte) []byte, decompress func(dst, src []byte) []byte) {
+	args := flag.Args()
+	if len(args) != 1 {
+		fatalf("usage: %s libbench %s <file>\n", os.Args[0], name)
+	}
+	buf, err := os.ReadFile(args[0])
+	if err != nil {
+		fatalf("reading file: %s", err)
+	}
+
+	comp := compress(buf)
+	var tmp []byte
+	var min time.Duration
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		start := time.Now()
+		tmp = decompress(tmp[:0], comp)
+		dur := time.Since(start)
+		if min == 0 || dur < min {
+			min = dur
+		}
+	}
+	multiplier := (1e12) / float64(time.Second)
+	mbps := (float64(len(buf)) / float64(min)) * multiplier
+	fmt.Printf("%d %.4g MB/s\n", len(comp), mbps)
+}
+
+func zstdCompress(level zstd.EncoderLevel) func([]byte) []byte {
+	return func(src []byte) []byte {
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(level))
+		if err != nil {
+			panic(err)
+		}
+		defer enc.Close()
+		return enc.EncodeAll(src, nil)
+	}
+}
+
+func zstdDecompress() func(dst, src []byte) []byte {
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		panic(err)
+	}
+	return func(dst, src []byte) []byte {
+		out, err := dec.DecodeAll(src, dst)
+		if err != nil {
+			panic(err)
+		}
+		return out
+	}
+}
+
+// lz4Level maps the CLI-style levels ("1", "9") used by main.go's
+// lz4-go compressors entries onto pierrec/lz4's compression level enum.
+func lz4Level(n int) lz4.CompressionLevel {
+	if n >= 9 {
+		return lz4.Level9
+	}
+	return lz4.Fast
+}
+
+func lz4Compress(level lz4.CompressionLevel) func([]byte) []byte {
+	return func(src []byte) []byte {
+		var out bytes.Buffer
+		w := lz4.NewWriter(&out)
+		if err := w.Apply(lz4.CompressionLevelOption(level)); err != nil {
+			panic(err)
+		}
+		if _, err := w.Write(src); err != nil {
+			panic(err)
+		}
+		if err := w.Close(); err != nil {
+			panic(err)
+		}
+		return out.Bytes()
+	}
+}
+
+func lz4Decompress(dst, src []byte) []byte {
+	out, err := io.ReadAll(lz4.NewReader(bytes.NewReader(src)))
+	if err != nil {
+		panic(err)
+	}
+	return append(dst, out...)
+}
+
+func snappyCompress(src []byte) []byte {
+	return snappy.Encode(nil, src)
+}
+
+func snappyDecompress(dst, src []byte) []byte {
+	out, err := snappy.Decode(dst, src)
+	if err != nil {
+		panic(err)
+	}
+	return out
+}
+
+func brotliCompress(quality int) func([]byte) []byte {
+	return func(src []byte) []byte {
+		var out bytes.Buffer
+		w := brotli.NewWriterLevel(&out, quality)
+		if _, err := w.Write(src); err != nil {
+			panic(err)
+		}
+		if err := w.Close(); err != nil {
+			panic(err)
+		}
+		return out.Bytes()
+	}
+}
+
+func brotliDecompress(dst, src []byte) []byte {
+	out, err := io.ReadAll(brotli.NewReader(bytes.NewReader(src)))
+	if err != nil {
+		panic(err)
+	}
+	return append(dst, out...)
+}
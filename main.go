@@ -271,6 +271,54 @@ var compressors = []compressor{
 		bench: benchProg("lz4", "-b9"),
 		parse: lz4Parse,
 	},
+	{
+		name:  "iguana_grpc",
+		avail: func() bool { return true },
+		bench: benchGRPC(),
+		parse: selfParse,
+	},
+	{
+		name:  "zstd-go-1",
+		avail: func() bool { return true },
+		bench: benchLibCmd("zstd", "1"),
+		parse: selfParse,
+	},
+	{
+		name:  "zstd-go-9",
+		avail: func() bool { return true },
+		bench: benchLibCmd("zstd", "9"),
+		parse: selfParse,
+	},
+	{
+		name:  "zstd-go-18",
+		avail: func() bool { return true },
+		bench: benchLibCmd("zstd", "18"),
+		parse: selfParse,
+	},
+	{
+		name:  "lz4-go-1",
+		avail: func() bool { return true },
+		bench: benchLibCmd("lz4", "1"),
+		parse: selfParse,
+	},
+	{
+		name:  "lz4-go-9",
+		avail: func() bool { return true },
+		bench: benchLibCmd("lz4", "9"),
+		parse: selfParse,
+	},
+	{
+		name:  "snappy-go",
+		avail: func() bool { return true },
+		bench: benchLibCmd("snappy", "0"),
+		parse: selfParse,
+	},
+	{
+		name:  "brotli-go",
+		avail: func() bool { return true },
+		bench: benchLibCmd("brotli", "6"),
+		parse: selfParse,
+	},
 }
 
 func fatalf(f string, args ...any) {
@@ -292,6 +340,16 @@ func main() {
 		benchMain()
 		os.Exit(0)
 	}
+	if len(os.Args) > 1 && os.Args[1] == "grpcbench" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		grpcBenchMain()
+		os.Exit(0)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "libbench" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		libBenchMain()
+		os.Exit(0)
+	}
 	var dashfile string
 	flag.BoolVar(&dashv, "v", false, "verbose")
 	flag.StringVar(&dashfile, "f", "", "file to benchmark (default: internal silesia.tar corpus)")
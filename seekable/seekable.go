@@ -0,0 +1,338 @@
+// Copyright (C) 2023 Sneller, Inc.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package seekable wraps the iguana frame format in a container that
+// supports random access, in the spirit of eStargz and zstd-chunked: a
+// small header, a sequence of independently-decodable frames, and a
+// trailing table-of-contents that lets a reader jump straight to the
+// frame covering a given byte range instead of scanning from the start.
+package seekable
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/SnellerInc/sneller/ion/zion/iguana"
+
+	"github.com/SnellerInc/compbench/internal/framecache"
+)
+
+// DefaultWindowSize is the maximum number of uncompressed bytes per frame
+// used when Options.WindowSize is zero.
+const DefaultWindowSize = 256 * 1024
+
+// DefaultThreshold is the entropy coding threshold used when
+// Options.Threshold is nil.
+const DefaultThreshold = 1.0
+
+// DefaultCacheFrames is the number of decoded frames kept in each
+// SeekableReader's LRU cache.
+const DefaultCacheFrames = 8
+
+var (
+	magic = [8]byte{'I', 'G', 'S', 'E', 'E', 'K', '0', '1'}
+
+	// ErrBadMagic is returned when a container's header does not start
+	// with the expected magic bytes.
+	ErrBadMagic = errors.New("seekable: bad magic")
+	// ErrTOCChecksum is returned when the table-of-contents footer's
+	// CRC32C does not match its contents.
+	ErrTOCChecksum = errors.New("seekable: table-of-contents checksum mismatch")
+	// ErrFrameLength is returned when a decoded frame's length
+	// disagrees with what the table-of-contents says it should be.
+	ErrFrameLength = errors.New("seekable: decoded frame length does not match table-of-contents")
+)
+
+const (
+	headerSize   = 16
+	tocEntrySize = 24
+	trailerSize  = 8 + 4 // toc offset + crc32c
+)
+
+// tocEntry describes one independently-decodable frame.
+type tocEntry struct {
+	UncompressedOffset uint64
+	CompressedOffset   uint64
+	UncompressedLen    uint32
+	CompressedLen      uint32
+}
+
+func (e *tocEntry) put(b []byte) {
+	binary.LittleEndian.PutUint64(b[0:8], e.UncompressedOffset)
+	binary.LittleEndian.PutUint64(b[8:16], e.CompressedOffset)
+	binary.LittleEndian.PutUint32(b[16:20], e.UncompressedLen)
+	binary.LittleEndian.PutUint32(b[20:24], e.CompressedLen)
+}
+
+func (e *tocEntry) get(b []byte) {
+	e.UncompressedOffset = binary.LittleEndian.Uint64(b[0:8])
+	e.CompressedOffset = binary.LittleEndian.Uint64(b[8:16])
+	e.UncompressedLen = binary.LittleEndian.Uint32(b[16:20])
+	e.CompressedLen = binary.LittleEndian.Uint32(b[20:24])
+}
+
+// Options configures a Writer.
+type Options struct {
+	// Threshold is the entropy coding threshold passed to
+	// iguana.Encoder.Compress. nil means DefaultThreshold; a pointer to
+	// 0 explicitly disables ANS entropy coding, so the zero value of
+	// float64 can't double as "unset".
+	Threshold *float64
+	// WindowSize bounds the number of uncompressed bytes per frame.
+	// Zero means DefaultWindowSize.
+	WindowSize int
+}
+
+func (o *Options) threshold() float64 {
+	if o.Threshold != nil {
+		return *o.Threshold
+	}
+	return DefaultThreshold
+}
+
+func (o *Options) setDefaults() {
+	if o.WindowSize <= 0 {
+		o.WindowSize = DefaultWindowSize
+	}
+}
+
+// Writer appends independently-decodable iguana frames to an
+// io.Writer and finalizes the table-of-contents footer on Close.
+type Writer struct {
+	w    io.Writer
+	opts Options
+	enc  iguana.Encoder
+
+	buf     []byte
+	uoffset uint64
+	coffset uint64
+	toc     []tocEntry
+	err     error
+}
+
+// NewWriter writes the container header and returns a Writer with
+// default Options.
+func NewWriter(w io.Writer) (*Writer, error) {
+	return NewWriterOptions(w, Options{})
+}
+
+// NewWriterOptions writes the container header and returns a Writer
+// configured with opts.
+func NewWriterOptions(w io.Writer, opts Options) (*Writer, error) {
+	opts.setDefaults()
+	var hdr [headerSize]byte
+	copy(hdr[:8], magic[:])
+	binary.LittleEndian.PutUint32(hdr[8:12], 1) // version
+	if _, err := w.Write(hdr[:]); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, opts: opts, coffset: headerSize}, nil
+}
+
+// Write implements io.Writer, buffering p into frames no larger than
+// Options.WindowSize.
+func (wr *Writer) Write(p []byte) (int, error) {
+	if wr.err != nil {
+		return 0, wr.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		room := wr.opts.WindowSize - len(wr.buf)
+		chunk := p
+		if len(chunk) > room {
+			chunk = chunk[:room]
+		}
+		wr.buf = append(wr.buf, chunk...)
+		p = p[len(chunk):]
+		if len(wr.buf) >= wr.opts.WindowSize {
+			if err := wr.flushFrame(); err != nil {
+				wr.err = err
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (wr *Writer) flushFrame() error {
+	if len(wr.buf) == 0 {
+		return nil
+	}
+	out, err := wr.enc.Compress(wr.buf, nil, float32(wr.opts.threshold()))
+	if err != nil {
+		return err
+	}
+	if _, err := wr.w.Write(out); err != nil {
+		return err
+	}
+	wr.toc = append(wr.toc, tocEntry{
+		UncompressedOffset: wr.uoffset,
+		CompressedOffset:   wr.coffset,
+		UncompressedLen:    uint32(len(wr.buf)),
+		CompressedLen:      uint32(len(out)),
+	})
+	wr.uoffset += uint64(len(wr.buf))
+	wr.coffset += uint64(len(out))
+	wr.buf = wr.buf[:0]
+	return nil
+}
+
+// Close flushes any buffered data and writes the table-of-contents
+// footer. It does not close the underlying io.Writer.
+func (wr *Writer) Close() error {
+	if wr.err != nil {
+		return wr.err
+	}
+	if err := wr.flushFrame(); err != nil {
+		wr.err = err
+		return err
+	}
+	tocOff := wr.coffset
+	toc := make([]byte, tocEntrySize*len(wr.toc))
+	for i := range wr.toc {
+		wr.toc[i].put(toc[i*tocEntrySize:])
+	}
+	if _, err := wr.w.Write(toc); err != nil {
+		return err
+	}
+	var trailer [trailerSize]byte
+	binary.LittleEndian.PutUint64(trailer[0:8], tocOff)
+	binary.LittleEndian.PutUint32(trailer[8:12], crc32.Checksum(toc, crc32.MakeTable(crc32.Castagnoli)))
+	_, err := wr.w.Write(trailer[:])
+	return err
+}
+
+// SeekableReader provides random access over a container written by
+// Writer, lazily decompressing only the frames needed to satisfy a
+// given ReadAt call.
+type SeekableReader struct {
+	r   io.ReaderAt
+	toc []tocEntry
+
+	mu    sync.Mutex
+	dec   iguana.Decoder
+	cache *framecache.Cache
+}
+
+// NewSeekableReader reads and validates the header and
+// table-of-contents footer of the container at r (which spans size
+// bytes), returning a reader ready to serve ReadAt calls.
+func NewSeekableReader(r io.ReaderAt, size int64) (*SeekableReader, error) {
+	if size < headerSize+trailerSize {
+		return nil, fmt.Errorf("seekable: container too small (%d bytes)", size)
+	}
+	var hdr [headerSize]byte
+	if _, err := r.ReadAt(hdr[:], 0); err != nil {
+		return nil, err
+	}
+	if string(hdr[:8]) != string(magic[:]) {
+		return nil, ErrBadMagic
+	}
+
+	var trailer [trailerSize]byte
+	if _, err := r.ReadAt(trailer[:], size-trailerSize); err != nil {
+		return nil, err
+	}
+	tocOff := binary.LittleEndian.Uint64(trailer[0:8])
+	wantCRC := binary.LittleEndian.Uint32(trailer[8:12])
+
+	tocLen := size - trailerSize - int64(tocOff)
+	if tocLen < 0 || tocLen%tocEntrySize != 0 {
+		return nil, fmt.Errorf("seekable: invalid table-of-contents length %d", tocLen)
+	}
+	toc := make([]byte, tocLen)
+	if _, err := r.ReadAt(toc, int64(tocOff)); err != nil {
+		return nil, err
+	}
+	if crc32.Checksum(toc, crc32.MakeTable(crc32.Castagnoli)) != wantCRC {
+		return nil, ErrTOCChecksum
+	}
+
+	entries := make([]tocEntry, tocLen/tocEntrySize)
+	for i := range entries {
+		entries[i].get(toc[i*tocEntrySize:])
+	}
+
+	return &SeekableReader{
+		r:     r,
+		toc:   entries,
+		cache: framecache.New(DefaultCacheFrames),
+	}, nil
+}
+
+// ReadAt implements io.ReaderAt over the uncompressed byte stream.
+func (sr *SeekableReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("seekable: negative offset %d", off)
+	}
+	i := sort.Search(len(sr.toc), func(i int) bool {
+		e := &sr.toc[i]
+		return e.UncompressedOffset+uint64(e.UncompressedLen) > uint64(off)
+	})
+	n := 0
+	for n < len(p) && i < len(sr.toc) {
+		e := &sr.toc[i]
+		frame, err := sr.decodeFrame(i, e)
+		if err != nil {
+			return n, err
+		}
+		skip := uint64(off) - e.UncompressedOffset
+		if skip > uint64(len(frame)) {
+			skip = uint64(len(frame))
+		}
+		c := copy(p[n:], frame[skip:])
+		n += c
+		off += int64(c)
+		i++
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// decodeFrame decodes frame i, consulting and populating sr.cache
+// (itself safe for concurrent use, as ReadAt's contract requires).
+// sr.mu only serializes the decode-and-insert sequence on a cache miss
+// so concurrent misses for the same frame don't do redundant work.
+func (sr *SeekableReader) decodeFrame(i int, e *tocEntry) ([]byte, error) {
+	if frame, ok := sr.cache.Get(i); ok {
+		return frame, nil
+	}
+
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if frame, ok := sr.cache.Get(i); ok {
+		return frame, nil
+	}
+
+	src := make([]byte, e.CompressedLen)
+	if _, err := sr.r.ReadAt(src, int64(e.CompressedOffset)); err != nil {
+		return nil, err
+	}
+	dst, err := sr.dec.DecompressTo(make([]byte, 0, e.UncompressedLen), src)
+	if err != nil {
+		return nil, err
+	}
+	if uint32(len(dst)) != e.UncompressedLen {
+		return nil, ErrFrameLength
+	}
+	sr.cache.Add(i, dst)
+	return dst, nil
+}
@@ -0,0 +1,112 @@
+// Copyright (C) 2023 Sneller, Inc.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package seekable
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// silesia loads the shared benchmark corpus used by the rest of the
+// repo. It is skipped (not failed) when run outside of a source
+// checkout that has the corpus available, since it lives under the
+// top-level package's testdata directory.
+func silesia(b *testing.B) []byte {
+	b.Helper()
+	path := filepath.Join("..", "testdata", "silesia.tar.gz")
+	f, err := os.Open(path)
+	if err != nil {
+		b.Skipf("silesia corpus not available: %s", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		b.Fatalf("ungzipping corpus: %s", err)
+	}
+	defer gz.Close()
+	buf, err := io.ReadAll(gz)
+	if err != nil {
+		b.Fatalf("reading corpus: %s", err)
+	}
+	return buf
+}
+
+// BenchmarkSeekMiddle measures the cost of a binary-searched
+// table-of-contents seek to the middle of the silesia corpus, as opposed to zstd --long
+// which must replay the window history from the start of the stream to
+// reach the same offset.
+func BenchmarkSeekMiddle(b *testing.B) {
+	data := silesia(b)
+	mid := int64(len(data) / 2)
+
+	b.Run("iguana_seekable", func(b *testing.B) {
+		var buf bytes.Buffer
+		wr, err := NewWriter(&buf)
+		if err != nil {
+			b.Fatalf("creating writer: %s", err)
+		}
+		if _, err := wr.Write(data); err != nil {
+			b.Fatalf("writing: %s", err)
+		}
+		if err := wr.Close(); err != nil {
+			b.Fatalf("closing: %s", err)
+		}
+		raw := buf.Bytes()
+
+		sr, err := NewSeekableReader(bytes.NewReader(raw), int64(len(raw)))
+		if err != nil {
+			b.Fatalf("opening seekable reader: %s", err)
+		}
+		out := make([]byte, 64*1024)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := sr.ReadAt(out, mid); err != nil && err != io.EOF {
+				b.Fatalf("ReadAt: %s", err)
+			}
+		}
+	})
+
+	b.Run("zstd_long_reseek", func(b *testing.B) {
+		if _, err := exec.LookPath("zstd"); err != nil {
+			b.Skip("zstd binary not available")
+		}
+		tmp, err := os.CreateTemp("", "silesia-*.zst")
+		if err != nil {
+			b.Fatalf("creating tempfile: %s", err)
+		}
+		defer os.Remove(tmp.Name())
+		cmd := exec.Command("zstd", "-q", "--long=27", "-o", tmp.Name(), "-f")
+		cmd.Stdin = bytes.NewReader(data)
+		if err := cmd.Run(); err != nil {
+			b.Skipf("zstd compress failed: %s", err)
+		}
+		tmp.Close()
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			// zstd --long has no random-access seek API: reaching
+			// byte `mid` requires decoding the stream from the start.
+			cmd := exec.Command("zstd", "-q", "-d", "--long=27", "-c", tmp.Name())
+			if err := cmd.Run(); err != nil {
+				b.Fatalf("zstd decompress failed: %s", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,167 @@
+// Copyright (C) 2023 Sneller, Inc.
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package seekable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"testing"
+)
+
+func testInput(n int) []byte {
+	buf := make([]byte, n)
+	for i := range buf {
+		buf[i] = byte((i*7 + i/251) % 251)
+	}
+	return buf
+}
+
+func writeContainer(t *testing.T, opts Options, in []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	wr, err := NewWriterOptions(&buf, opts)
+	if err != nil {
+		t.Fatalf("NewWriterOptions: %s", err)
+	}
+	if _, err := wr.Write(in); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if err := wr.Close(); err != nil {
+		t.Fatalf("Close: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := testInput(10 * 1024)
+	raw := writeContainer(t, Options{WindowSize: 4096}, in)
+
+	sr, err := NewSeekableReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		t.Fatalf("NewSeekableReader: %s", err)
+	}
+	out := make([]byte, len(in))
+	if _, err := sr.ReadAt(out, 0); err != nil {
+		t.Fatalf("ReadAt: %s", err)
+	}
+	if !bytes.Equal(out, in) {
+		t.Fatalf("round trip mismatch")
+	}
+}
+
+// TestReadAtSpansFrames exercises a read starting mid-frame and
+// crossing into the next one, to catch off-by-one errors in the
+// frame-boundary bookkeeping in ReadAt.
+func TestReadAtSpansFrames(t *testing.T) {
+	in := testInput(10 * 1024)
+	raw := writeContainer(t, Options{WindowSize: 4096}, in)
+
+	sr, err := NewSeekableReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		t.Fatalf("NewSeekableReader: %s", err)
+	}
+	const start = 4000
+	out := make([]byte, 200)
+	n, err := sr.ReadAt(out, start)
+	if err != nil {
+		t.Fatalf("ReadAt: %s", err)
+	}
+	if n != len(out) {
+		t.Fatalf("got %d bytes, want %d", n, len(out))
+	}
+	if !bytes.Equal(out, in[start:start+len(out)]) {
+		t.Fatalf("spanning read mismatch")
+	}
+}
+
+func TestReadAtEOF(t *testing.T) {
+	in := testInput(1024)
+	raw := writeContainer(t, Options{WindowSize: 512}, in)
+
+	sr, err := NewSeekableReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		t.Fatalf("NewSeekableReader: %s", err)
+	}
+	out := make([]byte, 16)
+	_, err = sr.ReadAt(out, int64(len(in)-8))
+	if err != io.EOF {
+		t.Fatalf("got error %v, want io.EOF", err)
+	}
+}
+
+func TestReadAtNegativeOffset(t *testing.T) {
+	in := testInput(256)
+	raw := writeContainer(t, Options{}, in)
+
+	sr, err := NewSeekableReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		t.Fatalf("NewSeekableReader: %s", err)
+	}
+	if _, err := sr.ReadAt(make([]byte, 8), -1); err == nil {
+		t.Fatal("ReadAt with negative offset: got nil error, want one")
+	}
+}
+
+func TestNewSeekableReaderBadMagic(t *testing.T) {
+	in := testInput(256)
+	raw := writeContainer(t, Options{}, in)
+	raw[0] ^= 0xff
+
+	_, err := NewSeekableReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != ErrBadMagic {
+		t.Fatalf("got error %v, want ErrBadMagic", err)
+	}
+}
+
+func TestNewSeekableReaderTOCChecksum(t *testing.T) {
+	in := testInput(256)
+	raw := writeContainer(t, Options{}, in)
+	raw[len(raw)-trailerSize-1] ^= 0xff
+
+	_, err := NewSeekableReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != ErrTOCChecksum {
+		t.Fatalf("got error %v, want ErrTOCChecksum", err)
+	}
+}
+
+func TestNewSeekableReaderTooSmall(t *testing.T) {
+	_, err := NewSeekableReader(bytes.NewReader([]byte("short")), 5)
+	if err == nil {
+		t.Fatal("NewSeekableReader on a too-small container: got nil error, want one")
+	}
+}
+
+func TestReadAtFrameLengthMismatch(t *testing.T) {
+	in := testInput(256)
+	raw := writeContainer(t, Options{}, in)
+
+	// Rewriting the single TOC entry's uncompressed length (and its
+	// CRC, so the footer still validates) makes the intact frame decode
+	// to fewer bytes than the table-of-contents claims.
+	toc := raw[len(raw)-trailerSize-tocEntrySize : len(raw)-trailerSize]
+	binary.LittleEndian.PutUint32(toc[16:20], uint32(len(in)+1))
+	binary.LittleEndian.PutUint32(raw[len(raw)-4:], crc32.Checksum(toc, crc32.MakeTable(crc32.Castagnoli)))
+
+	sr, err := NewSeekableReader(bytes.NewReader(raw), int64(len(raw)))
+	if err != nil {
+		t.Fatalf("NewSeekableReader: %s", err)
+	}
+	_, err = sr.ReadAt(make([]byte, len(in)), 0)
+	if err != ErrFrameLength {
+		t.Fatalf("got error %v, want ErrFrameLength", err)
+	}
+}